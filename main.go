@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 
 	"github.com/asheeshrana/gime/msgime"
 )
@@ -10,5 +11,5 @@ func main() {
 	var cfile, _ = msgime.NewCompoundFile("/temp/CV.doc")
 	var mimeType = cfile.GetMimeType()
 	fmt.Println("Mime type of the file = " + mimeType)
-	cfile.PrintFileInfo()
+	msgime.Fprint(os.Stdout, cfile.Info())
 }
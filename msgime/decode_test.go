@@ -0,0 +1,116 @@
+package msgime
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeUUID(t *testing.T) {
+	// {00020906-0000-0000-c000-000000000046}, the Word 97-2003 root CLSID
+	value := []byte{
+		0x06, 0x09, 0x02, 0x00, //little-endian Data1
+		0x00, 0x00, //little-endian Data2
+		0x00, 0x00, //little-endian Data3
+		0xc0, 0x00, //big-endian Data4[0:2]
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x46, //big-endian Data4[2:8]
+	}
+	want := "00020906-0000-0000-c000-000000000046"
+	if got := decodeUUID(value); got != want {
+		t.Errorf("decodeUUID() = %q, want %q", got, want)
+	}
+}
+
+func TestFileTimeToTime(t *testing.T) {
+	if got := fileTimeToTime(0); !got.IsZero() {
+		t.Errorf("fileTimeToTime(0) = %v, want zero Time", got)
+	}
+
+	const filetimeToUnixEpoch = 116444736000000000
+	want := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	ft := uint64(filetimeToUnixEpoch) + uint64(want.Unix())*10000000
+	if got := fileTimeToTime(ft); !got.Equal(want) {
+		t.Errorf("fileTimeToTime(%d) = %v, want %v", ft, got, want)
+	}
+}
+
+func TestDecodeEntryNameTrimsNullTerminator(t *testing.T) {
+	cFile := &defaultCompoundFile{byteOrder: binary.LittleEndian}
+	var raw directoryEntry
+	setEntryName(&raw, "Hello")
+
+	if got := cFile.decodeEntryName(&raw); got != "Hello" {
+		t.Errorf("decodeEntryName() = %q, want %q", got, "Hello")
+	}
+}
+
+// fakeSectorSource is an io.ReaderAt backed by a sparse map of regular
+// sectors, keyed by sector ID, used to unit test FAT/DIFAT walking without
+// assembling a full compound file
+type fakeSectorSource struct {
+	sectors map[uint32][]byte
+}
+
+func (f *fakeSectorSource) size() int64 {
+	max := int64(512)
+	for id, data := range f.sectors {
+		if end := 512 + int64(id)*512 + int64(len(data)); end > max {
+			max = end
+		}
+	}
+	return max
+}
+
+func (f *fakeSectorSource) ReadAt(p []byte, off int64) (int, error) {
+	if off < 512 {
+		return 0, fmt.Errorf("fakeSectorSource: offset %d below sector region", off)
+	}
+	sectorID := uint32((off - 512) / 512)
+	data, ok := f.sectors[sectorID]
+	if !ok {
+		return 0, fmt.Errorf("fakeSectorSource: no data for sector %d", sectorID)
+	}
+	return copy(p, data), nil
+}
+
+// TestCollectFATSectorIDsFollowsDIFATContinuation checks that the FAT sector
+// IDs embedded directly in the header are combined with the ones listed in
+// a DIFAT continuation sector, in order, with FREESECT entries skipped.
+func TestCollectFATSectorIDsFollowsDIFATContinuation(t *testing.T) {
+	var header cfbHeader
+	header.SizeOfSector = 9 //512-byte sectors
+	header.FirstPartOfMasterAllocationTable[0] = 10
+	header.FirstPartOfMasterAllocationTable[1] = 11
+	for i := 2; i < len(header.FirstPartOfMasterAllocationTable); i++ {
+		header.FirstPartOfMasterAllocationTable[i] = sectorFreeSect
+	}
+	header.FistMasterSectorID = 20 //DIFAT continuation sector
+
+	//One FAT sector ID (12), the rest FREESECT, terminated by ENDOFCHAIN in
+	//the sector's last 4 bytes
+	difatSector := make([]byte, 512)
+	binary.LittleEndian.PutUint32(difatSector[0:4], 12)
+	for i := 4; i < len(difatSector)-4; i += 4 {
+		binary.LittleEndian.PutUint32(difatSector[i:i+4], sectorFreeSect)
+	}
+	binary.LittleEndian.PutUint32(difatSector[len(difatSector)-4:], sectorEndOfChain)
+
+	source := &fakeSectorSource{sectors: map[uint32][]byte{20: difatSector}}
+	cFile := &defaultCompoundFile{
+		header:    header,
+		byteOrder: binary.LittleEndian,
+		source:    source,
+		size:      source.size(),
+	}
+
+	ids, err := cFile.collectFATSectorIDs()
+	if err != nil {
+		t.Fatalf("collectFATSectorIDs: %v", err)
+	}
+	want := []uint32{10, 11, 12}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("collectFATSectorIDs() = %v, want %v", ids, want)
+	}
+}
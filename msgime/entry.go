@@ -0,0 +1,411 @@
+package msgime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf16"
+)
+
+// directoryEntrySize is the fixed on-disk size of a single directory entry
+const directoryEntrySize = 64 + 64
+
+// noStream is the DirID sentinel meaning "no child"
+const noStream uint32 = 0xFFFFFFFF
+
+// Sector chain special values, see [MS-CFB] 2.1
+const (
+	sectorFreeSect   uint32 = 0xFFFFFFFF
+	sectorEndOfChain uint32 = 0xFFFFFFFE
+	sectorFATSect    uint32 = 0xFFFFFFFD
+	sectorDIFATSect  uint32 = 0xFFFFFFFC
+)
+
+// Directory entry type byte, see [MS-CFB] 2.6.1
+const (
+	direntTypeEmpty     byte = 0x00
+	direntTypeStorage   byte = 0x01
+	direntTypeStream    byte = 0x02
+	direntTypeLockBytes byte = 0x03
+	direntTypeProperty  byte = 0x04
+	direntTypeRoot      byte = 0x05
+)
+
+// directoryEntry mirrors a single 128-byte directory entry, see [MS-CFB] 2.6.1
+type directoryEntry struct {
+	EntryName                   [64]byte
+	SizeOfEntryNameInCharacters uint16
+	Type                        byte     //00H = Empty 03H = LockBytes (unknown), 01H = User storage 04H = Property (unknown), 02H = User stream 05H = Root storage
+	NodeColorr                  byte     //00H = Red 01H = Black. It is a read-black tree
+	DirIDOfLeftChild            uint32   //DirID of the left child node inside the red-black tree of all direct members of the parent storage (if this entry is a user storage or stream), –1 if there is no left child
+	DirIDOfRighttChild          uint32   //DirID of the right child node inside the red-black tree of all direct members of the parent storage (if this entry is a user storage or stream), –1 if there is no right child
+	DirIDOfRoot                 uint32   //DirID of the root node entry of the red-black tree of all storage members (if this entry is a storage), –1 otherwise
+	CLSID                       [16]byte //UUID representing CLSID
+	UserFlags                   uint32
+	EntryCreationTimpestamp     uint64
+	EntryModificationTimpestamp uint64
+	FistSectorID                uint32
+	TotalStreamSizeInBytes      uint32
+	Reserved                    uint32
+}
+
+// decodeDirectoryEntry decodes a raw directoryEntrySize-byte directory entry
+func decodeDirectoryEntry(raw []byte, order binary.ByteOrder) (*directoryEntry, error) {
+	if len(raw) != directoryEntrySize {
+		return nil, fmt.Errorf("msgime: directory entry must be exactly %d bytes, got %d", directoryEntrySize, len(raw))
+	}
+	var d directoryEntry
+	if err := binary.Read(bytes.NewReader(raw), order, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// EntryType identifies the kind of a directory entry inside a compound file
+type EntryType int
+
+const (
+	//EntryTypeEmpty is an unused directory slot
+	EntryTypeEmpty EntryType = iota
+	//EntryTypeStorage is a user storage, analogous to a directory
+	EntryTypeStorage
+	//EntryTypeStream is a user stream, analogous to a file
+	EntryTypeStream
+	//EntryTypeLockBytes is reserved and never produced by the common writers
+	EntryTypeLockBytes
+	//EntryTypeProperty is reserved and never produced by the common writers
+	EntryTypeProperty
+	//EntryTypeRoot is the single root storage entry present in every compound file
+	EntryTypeRoot
+)
+
+// Entry describes a single directory entry discovered while walking a compound file
+type Entry struct {
+	//Name is the entry name decoded from UTF-16
+	Name string
+	//Type is the kind of entry (storage, stream or root)
+	Type EntryType
+	//Size is the stream size in bytes, zero for storages
+	Size uint64
+	//CLSID is the entry's associated class ID, formatted as a UUID string
+	CLSID string
+	//Created is the entry creation timestamp, zero value if not set
+	Created time.Time
+	//Modified is the entry modification timestamp, zero value if not set
+	Modified time.Time
+	//Path holds the names of the parent storages, root storage excluded
+	Path []string
+
+	dirID         int
+	cfile         *defaultCompoundFile
+	firstSectorID uint32
+	pos           int64
+	//data caches the materialized stream content so repeated reads don't
+	//re-walk the FAT/SSAT chain from scratch; loaded lazily on first ReadAt
+	data       []byte
+	dataLoaded bool
+}
+
+// dirRef is the depth-first traversal order entry, paired with its parent path
+type dirRef struct {
+	dirID int
+	path  []string
+}
+
+// Next returns the next directory entry in depth-first order, or io.EOF once
+// every storage and stream has been visited. The traversal (and the
+// directory/FAT load it depends on) happens lazily on the first call.
+func (cFile *defaultCompoundFile) Next() (*Entry, error) {
+	if cFile.entryOrder == nil {
+		if err := cFile.buildEntryOrder(); err != nil {
+			return nil, err
+		}
+	}
+	if cFile.iterIndex >= len(cFile.entryOrder) {
+		return nil, io.EOF
+	}
+	ref := cFile.entryOrder[cFile.iterIndex]
+	cFile.iterIndex++
+	return cFile.entryAt(ref), nil
+}
+
+// Close releases the underlying source, if it supports being closed
+func (cFile *defaultCompoundFile) Close() error {
+	if closer, ok := cFile.source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// buildEntryOrder loads the FAT and the full directory chain, then walks the
+// red-black tree of directory entries starting at the root storage, producing
+// a depth-first visitation order
+func (cFile *defaultCompoundFile) buildEntryOrder() error {
+	if err := cFile.ensureFAT(); err != nil {
+		return err
+	}
+	if err := cFile.loadDirectoryEntries(); err != nil {
+		return err
+	}
+
+	visited := make(map[int]bool)
+	var order []dirRef
+
+	var walk func(dirID uint32, path []string) error
+	walk = func(dirID uint32, path []string) error {
+		if dirID == noStream {
+			return nil
+		}
+		id := int(dirID)
+		if id < 0 || id >= len(cFile.directoryEntries) {
+			return fmt.Errorf("msgime: directory ID %d out of range (%d entries)", dirID, len(cFile.directoryEntries))
+		}
+		if visited[id] {
+			return errors.New("msgime: cycle detected in directory red-black tree")
+		}
+		visited[id] = true
+
+		raw := cFile.directoryEntries[id]
+
+		if err := walk(raw.DirIDOfLeftChild, path); err != nil {
+			return err
+		}
+
+		order = append(order, dirRef{dirID: id, path: path})
+
+		if raw.Type == direntTypeStorage || raw.Type == direntTypeRoot {
+			childPath := path
+			if raw.Type == direntTypeStorage {
+				childPath = append(append([]string{}, path...), cFile.decodeEntryName(raw))
+			}
+			if err := walk(raw.DirIDOfRoot, childPath); err != nil {
+				return err
+			}
+		}
+
+		return walk(raw.DirIDOfRighttChild, path)
+	}
+
+	if err := walk(0, nil); err != nil {
+		return err
+	}
+	cFile.entryOrder = order
+	return nil
+}
+
+// loadDirectoryEntries follows the directory stream's sector chain (starting
+// at the header's FirstSectorID) through the FAT, decoding every fixed-size
+// directory entry it contains
+func (cFile *defaultCompoundFile) loadDirectoryEntries() error {
+	sectorBytes := cFile.sectorByteSize()
+	entriesPerSector := int(sectorBytes) / directoryEntrySize
+
+	sectorID := cFile.header.FirstSectorID
+	visited := make(map[uint32]bool)
+	var entries []*directoryEntry
+	for sectorID != sectorEndOfChain {
+		if visited[sectorID] {
+			return errors.New("msgime: cycle detected while reading directory sector chain")
+		}
+		visited[sectorID] = true
+
+		data, err := cFile.readSector(sectorID)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < entriesPerSector; i++ {
+			entry, err := decodeDirectoryEntry(data[i*directoryEntrySize:(i+1)*directoryEntrySize], cFile.byteOrder)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+
+		next, err := cFile.fatEntry(sectorID)
+		if err != nil {
+			return err
+		}
+		sectorID = next
+	}
+	cFile.directoryEntries = entries
+	return nil
+}
+
+// buildFAT reads every FAT sector ID (the 109 embedded in the header, plus
+// any from the DIFAT continuation chain) and loads each referenced sector,
+// producing a slice where fat[sectorID] is the sector that follows it in its
+// chain.
+func (cFile *defaultCompoundFile) buildFAT() error {
+	entriesPerSector := int(cFile.sectorByteSize()) / 4
+
+	fatSectorIDs, err := cFile.collectFATSectorIDs()
+	if err != nil {
+		return err
+	}
+
+	var fat []uint32
+	for _, fatSectorID := range fatSectorIDs {
+		data, err := cFile.readSector(fatSectorID)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < entriesPerSector; j++ {
+			fat = append(fat, cFile.byteOrder.Uint32(data[j*4:j*4+4]))
+		}
+	}
+	cFile.fat = fat
+	return nil
+}
+
+// collectFATSectorIDs gathers every FAT sector ID: the 109 embedded in the
+// header's FirstPartOfMasterAllocationTable, followed by any listed in the
+// DIFAT continuation chain starting at FistMasterSectorID. Each DIFAT sector
+// holds sectorByteSize/4 - 1 FAT sector IDs, with the next DIFAT sector ID
+// (or a terminator) in its last 4 bytes.
+func (cFile *defaultCompoundFile) collectFATSectorIDs() ([]uint32, error) {
+	var ids []uint32
+	for _, id := range cFile.header.FirstPartOfMasterAllocationTable {
+		if id == sectorFreeSect {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	entriesPerDIFATSector := int(cFile.sectorByteSize())/4 - 1
+	visited := make(map[uint32]bool)
+	sectorID := cFile.header.FistMasterSectorID
+	for sectorID != sectorEndOfChain && sectorID != noStream {
+		if visited[sectorID] {
+			return nil, errors.New("msgime: cycle detected while reading DIFAT sector chain")
+		}
+		visited[sectorID] = true
+
+		data, err := cFile.readSector(sectorID)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < entriesPerDIFATSector; j++ {
+			id := cFile.byteOrder.Uint32(data[j*4 : j*4+4])
+			if id == sectorFreeSect {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		sectorID = cFile.byteOrder.Uint32(data[entriesPerDIFATSector*4 : entriesPerDIFATSector*4+4])
+	}
+	return ids, nil
+}
+
+// ensureFAT builds the FAT on first use and is a no-op on later calls
+func (cFile *defaultCompoundFile) ensureFAT() error {
+	if cFile.fat != nil {
+		return nil
+	}
+	return cFile.buildFAT()
+}
+
+// fatEntry returns the sector that follows sectorID in its chain
+func (cFile *defaultCompoundFile) fatEntry(sectorID uint32) (uint32, error) {
+	if int(sectorID) >= len(cFile.fat) {
+		return 0, fmt.Errorf("msgime: sector %d out of range of FAT (%d entries)", sectorID, len(cFile.fat))
+	}
+	return cFile.fat[sectorID], nil
+}
+
+// readSector reads a single full sector given its sector ID
+func (cFile *defaultCompoundFile) readSector(sectorID uint32) ([]byte, error) {
+	sectorBytes := cFile.sectorByteSize()
+	offset := (int64(sectorID) + 1) * int64(sectorBytes)
+	if offset+int64(sectorBytes) > cFile.size {
+		return nil, fmt.Errorf("msgime: sector %d at offset %d extends past end of source (%d bytes)", sectorID, offset, cFile.size)
+	}
+	return read(cFile.source, offset, int(sectorBytes))
+}
+
+// sectorByteSize returns the size in bytes of a regular sector. The header
+// stores it as a power-of-two exponent rather than a literal byte count.
+func (cFile *defaultCompoundFile) sectorByteSize() uint16 {
+	return uint16(1) << cFile.header.SizeOfSector
+}
+
+// entryAt decodes the directory entry referenced by ref into an Entry
+func (cFile *defaultCompoundFile) entryAt(ref dirRef) *Entry {
+	raw := cFile.directoryEntries[ref.dirID]
+
+	return &Entry{
+		Name:          cFile.decodeEntryName(raw),
+		Type:          decodeEntryType(raw.Type),
+		Size:          cFile.decodeStreamSize(raw),
+		CLSID:         decodeUUID(raw.CLSID[:]),
+		Created:       fileTimeToTime(raw.EntryCreationTimpestamp),
+		Modified:      fileTimeToTime(raw.EntryModificationTimpestamp),
+		Path:          ref.path,
+		dirID:         ref.dirID,
+		cfile:         cFile,
+		firstSectorID: raw.FistSectorID,
+	}
+}
+
+// decodeStreamSize decodes a directory entry's stream size. CFBv3 files use
+// only the 32-bit TotalStreamSizeInBytes field (the trailing Reserved field
+// must be zero); CFBv4 files use the full 64 bits, with Reserved holding the
+// high-order 32 bits.
+func (cFile *defaultCompoundFile) decodeStreamSize(raw *directoryEntry) uint64 {
+	if cFile.header.VersionNumber < 4 {
+		return uint64(raw.TotalStreamSizeInBytes)
+	}
+	return uint64(raw.Reserved)<<32 | uint64(raw.TotalStreamSizeInBytes)
+}
+
+// decodeEntryType maps the raw directory entry type byte to an EntryType
+func decodeEntryType(t byte) EntryType {
+	switch t {
+	case direntTypeStorage:
+		return EntryTypeStorage
+	case direntTypeStream:
+		return EntryTypeStream
+	case direntTypeRoot:
+		return EntryTypeRoot
+	case direntTypeLockBytes:
+		return EntryTypeLockBytes
+	case direntTypeProperty:
+		return EntryTypeProperty
+	default:
+		return EntryTypeEmpty
+	}
+}
+
+// decodeEntryName decodes the UTF-16 EntryName field, trimmed to
+// SizeOfEntryNameInCharacters/2 - 1 runes (the field length includes the
+// trailing null terminator)
+func (cFile *defaultCompoundFile) decodeEntryName(raw *directoryEntry) string {
+	charCount := raw.SizeOfEntryNameInCharacters
+
+	runeCount := 0
+	if charCount >= 2 {
+		runeCount = int(charCount)/2 - 1
+	}
+	if runeCount > len(raw.EntryName)/2 {
+		runeCount = len(raw.EntryName) / 2
+	}
+
+	chars := make([]uint16, runeCount)
+	for i := 0; i < runeCount; i++ {
+		chars[i] = cFile.byteOrder.Uint16(raw.EntryName[i*2 : i*2+2])
+	}
+	return string(utf16.Decode(chars))
+}
+
+// fileTimeToTime decodes a 64-bit Windows FILETIME (100ns intervals since
+// 1601-01-01) into a time.Time. A zero FILETIME yields the zero Time.
+func fileTimeToTime(fileTime uint64) time.Time {
+	if fileTime == 0 {
+		return time.Time{}
+	}
+	//Number of 100ns intervals between the FILETIME epoch (1601-01-01) and the Unix epoch
+	const filetimeToUnixEpoch = 116444736000000000
+	return time.Unix(0, int64(fileTime-filetimeToUnixEpoch)*100).UTC()
+}
@@ -1,186 +1,272 @@
 package msgime
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"time"
 )
 
-//fieldPosition describes the position of the fields in a structure
-type fieldPosition struct {
-	offset int
-	size   int
-}
-
-//uuidMimeTypeMap maps the CSID of root directory with the corresponding mimetype
-var uuidMimeTypeMap = map[string]string{
-	"00020906-0000-0000-c000-000000000046": "application/msword",
-	"00020820-0000-0000-c000-000000000046": "application/vnd.ms-excel",
-	"00020810-0000-0000-c000-000000000046": "application/vnd.ms-excel",
-}
-
-//validFileIdentifiers indicate the fileidentifiers or starting string
-//which identifies a compound file that we are targetting
+// validFileIdentifiers indicate the fileidentifiers or starting string
+// which identifies a compound file that we are targetting
 var validFileIdentifiers = [][]byte{
 	{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1},
 	//Old format of file identifier beta 2 files (late ’92)
 	{0x0e, 0x11, 0xfc, 0x0d, 0xd0, 0xcf, 0x11, 0xe0},
 }
 
-//headerMap describes the structure of header
-var headerMap = map[string]fieldPosition{
-	"FileIdentifier":                                 fieldPosition{offset: 0, size: 8},
-	"UUIDOfFile":                                     fieldPosition{offset: 8, size: 16},
-	"RevisionNumber":                                 fieldPosition{offset: 24, size: 2},
-	"VersionNumber":                                  fieldPosition{offset: 26, size: 2},
-	"ByteOrderIdentifier":                            fieldPosition{offset: 28, size: 2},
-	"SizeOfSector":                                   fieldPosition{offset: 30, size: 2},
-	"SizeOfShortSector":                              fieldPosition{offset: 32, size: 2},
-	"Reserved":                                       fieldPosition{offset: 34, size: 10},
-	"TotalSectors":                                   fieldPosition{offset: 44, size: 4},
-	"FirstSectorID":                                  fieldPosition{offset: 48, size: 4},
-	"Reserved1":                                      fieldPosition{offset: 52, size: 4},
-	"MinSizeOfStdStream":                             fieldPosition{offset: 56, size: 4},
-	"FirstShortSectorID":                             fieldPosition{offset: 60, size: 4},
-	"TotalSectorsUsedForShortSectorAllocationTable":  fieldPosition{offset: 64, size: 4},
-	"FistMasterSectorID":                             fieldPosition{offset: 68, size: 4},
-	"TotalSectorsUsedForMasterSectorAllocationTable": fieldPosition{offset: 72, size: 4},
-	"FirstPartOfMasterAllocationTable":               fieldPosition{offset: 76, size: 436},
+// cfbHeader mirrors the 512-byte compound file header, see [MS-CFB] 2.2
+type cfbHeader struct {
+	FileIdentifier                                 [8]byte
+	UUIDOfFile                                     [16]byte
+	RevisionNumber                                 uint16
+	VersionNumber                                  uint16
+	ByteOrderIdentifier                            uint16
+	SizeOfSector                                   uint16
+	SizeOfShortSector                              uint16
+	Reserved                                       [10]byte
+	TotalSectors                                   uint32
+	FirstSectorID                                  uint32
+	Reserved1                                      uint32
+	MinSizeOfStdStream                             uint32
+	FirstShortSectorID                             uint32
+	TotalSectorsUsedForShortSectorAllocationTable  uint32
+	FistMasterSectorID                             uint32
+	TotalSectorsUsedForMasterSectorAllocationTable uint32
+	FirstPartOfMasterAllocationTable               [109]uint32
 }
 
-//directoryMap describes the structure of directory
-var directoryMap = map[string]fieldPosition{
-	"EntryName":                   fieldPosition{offset: 0, size: 64},
-	"SizeOfEntryNameInCharacters": fieldPosition{offset: 64, size: 2},
-	"Type":                        fieldPosition{offset: 66, size: 1},  //00H = Empty 03H = LockBytes (unknown), 01H = User storage 04H = Property (unknown), 02H = User stream 05H = Root storage
-	"NodeColorr":                  fieldPosition{offset: 67, size: 1},  //00H = Red 01H = Black. It is a read-black tree
-	"DirIDOfLeftChild":            fieldPosition{offset: 68, size: 4},  //DirID of the left child node inside the red-black tree of all direct members of the parent storage (if this entry is a user storage or stream), –1 if there is no left child
-	"DirIDOfRighttChild":          fieldPosition{offset: 72, size: 4},  //DirID of the right child node inside the red-black tree of all direct members of the parent storage (if this entry is a user storage or stream), –1 if there is no right child
-	"DirIDOfRoot":                 fieldPosition{offset: 76, size: 4},  //DirID of the root node entry of the red-black tree of all storage members (if this entry is a storage), –1 otherwise
-	"CLSID":                       fieldPosition{offset: 80, size: 16}, //UUID representing CLSID
-	"UserFlags":                   fieldPosition{offset: 96, size: 4},
-	"EntryCreationTimpestamp":     fieldPosition{offset: 100, size: 8},
-	"EntryModificationTimpestamp": fieldPosition{offset: 108, size: 8},
-	"FistSectorID":                fieldPosition{offset: 116, size: 4},
-	"TotalStreamSizeInBytes":      fieldPosition{offset: 120, size: 4},
-	"Reserved":                    fieldPosition{offset: 124, size: 4},
-}
-
-//CompoundFile describes the interface with the methods that we want to expose
+// CompoundFile describes the interface with the methods that we want to expose
 type CompoundFile interface {
 	//GetMimeType returns the mimetype of the compound file
 	GetMimeType() string
-	//PrintFileInfo prints specific information of the compound file
-	PrintFileInfo()
+	//Info returns descriptive information about the compound file's header
+	//and directory entries, see Fprint to render it as text
+	Info() FileInfo
+	//Next returns the next directory entry (storage or stream) found while
+	//walking the compound file, in depth-first order, and io.EOF once every
+	//entry has been visited
+	Next() (*Entry, error)
+	//Close releases any resources held to read the compound file
+	Close() error
+	//DetectFormat identifies the document format stored in the compound file
+	DetectFormat() Format
 }
 
-//defaultCompoundFileInterface is the interface implemented by the default implmentation of CompoundFile
+// defaultCompoundFileInterface is the interface implemented by the default implmentation of CompoundFile
 type defaultCompoundFileInterface interface {
 	CompoundFile
 	//Private methods
-	getValueFromHeader(fieldname string) []byte
-	getValueFromRootDirectory(fieldname string) []byte
-	isLittleEndian() bool
-	setHeader(header []byte) CompoundFile
-	setRootDirectory(rootDirectory []byte) CompoundFile
 	setFilename(filepath string) CompoundFile
 }
 
-//defaultCompundFile provides the default implementation of the compound file structure
+// defaultCompundFile provides the default implementation of the compound file structure
 type defaultCompoundFile struct {
 	filename           string
-	header             []byte
-	rootDirectoryEntry []byte
+	header             cfbHeader
+	littleEndian       bool
+	byteOrder          binary.ByteOrder
+	rootDirectoryEntry directoryEntry
+
+	//source is kept around for the lifetime of the CompoundFile so that
+	//directory entries and stream contents can be read lazily
+	source io.ReaderAt
+	//size is the total size in bytes of source, used to bounds-check reads
+	size int64
+	//fat maps a sector ID to the sector that follows it in its chain
+	fat []uint32
+	//ssat is the mini-FAT: maps a short sector ID to the short sector that follows it
+	ssat []uint32
+	//miniStream holds the mini-stream container (the root entry's own stream
+	//content), lazily loaded the first time a small stream is read
+	miniStream       []byte
+	miniStreamLoaded bool
+	//directoryEntries holds every decoded directory entry, indexed by DirID
+	directoryEntries []*directoryEntry
+	//entryOrder is the depth-first traversal order computed by buildEntryOrder
+	entryOrder []dirRef
+	//iterIndex is the cursor into entryOrder consumed by Next
+	iterIndex int
 }
 
 func (cFile *defaultCompoundFile) GetMimeType() string {
-	clsID := cFile.getValueFromRootDirectory("CLSID")
-	uuID := decodeValueAsUUID(cFile.isLittleEndian(), clsID)
-	if mimeType, ok := uuidMimeTypeMap[uuID]; ok {
-		return mimeType
-	}
-	return "application/octet-stream"
+	return cFile.DetectFormat().Mime
 }
 
-func (cFile *defaultCompoundFile) PrintFileInfo() {
-	printValue("FileIdentifier", cFile.getValueFromHeader("FileIdentifier"))
-	fmt.Printf("Filename = %s\n", cFile.filename)
-	fmt.Printf("UUIDOfFile = %s\n", decodeValueAsUUID(cFile.isLittleEndian(), cFile.getValueFromHeader("UUIDOfFile")))
-	printValue("RevisionNumber", cFile.getValueFromHeader("RevisionNumber"))
-	printValue("VersionNumber", cFile.getValueFromHeader("VersionNumber"))
-	fmt.Printf("LittleEndian = %t", cFile.isLittleEndian())
-	fmt.Printf("Type = %v", cFile.getValueFromRootDirectory("Type"))
+// FileInfo holds descriptive information decoded out of a compound file's
+// header and directory entries
+type FileInfo struct {
+	FileIdentifier   []byte
+	UUID             string
+	Revision         uint16
+	Version          uint16
+	LittleEndian     bool
+	SectorSize       uint16
+	MiniSectorSize   uint16
+	MiniStreamCutoff uint32
+	TotalSectors     uint32
+	RootCLSID        string
+	DirectoryEntries []EntryInfo
 }
 
-func (cFile *defaultCompoundFile) getValueFromHeader(fieldname string) []byte {
-	var fieldValue []byte
-	if fieldInfo, ok := headerMap[fieldname]; ok {
-		fieldValue = cFile.header[fieldInfo.offset : fieldInfo.offset+fieldInfo.size]
-	}
-	return fieldValue
+// EntryInfo holds descriptive information about a single directory entry, as
+// exposed through FileInfo.DirectoryEntries
+type EntryInfo struct {
+	Name     string
+	Type     EntryType
+	Size     uint64
+	CLSID    string
+	Created  time.Time
+	Modified time.Time
+	Path     []string
 }
 
-func (cFile *defaultCompoundFile) getValueFromRootDirectory(fieldname string) []byte {
-	var fieldValue []byte
-	if fieldInfo, ok := directoryMap[fieldname]; ok {
-		fieldValue = cFile.rootDirectoryEntry[fieldInfo.offset : fieldInfo.offset+fieldInfo.size]
+// Info walks the compound file, if it hasn't been walked already, and returns
+// descriptive information about its header and directory entries
+func (cFile *defaultCompoundFile) Info() FileInfo {
+	//Errors walking the directory tree are deliberately ignored here, the
+	//same way DetectFormat ignores them: Info degrades to an empty
+	//DirectoryEntries list rather than failing outright
+	cFile.eachEntry(func(*Entry) bool { return true })
+
+	entries := make([]EntryInfo, len(cFile.entryOrder))
+	for i, ref := range cFile.entryOrder {
+		entry := cFile.entryAt(ref)
+		entries[i] = EntryInfo{
+			Name:     entry.Name,
+			Type:     entry.Type,
+			Size:     entry.Size,
+			CLSID:    entry.CLSID,
+			Created:  entry.Created,
+			Modified: entry.Modified,
+			Path:     entry.Path,
+		}
 	}
-	return fieldValue
-}
 
-func (cFile *defaultCompoundFile) isLittleEndian() bool {
-	byteOrder := cFile.getValueFromHeader("ByteOrderIdentifier")
-	return byteOrder[0] == 0xFE
+	return FileInfo{
+		FileIdentifier:   cFile.header.FileIdentifier[:],
+		UUID:             decodeUUID(cFile.header.UUIDOfFile[:]),
+		Revision:         cFile.header.RevisionNumber,
+		Version:          cFile.header.VersionNumber,
+		LittleEndian:     cFile.littleEndian,
+		SectorSize:       cFile.sectorByteSize(),
+		MiniSectorSize:   cFile.shortSectorByteSize(),
+		MiniStreamCutoff: cFile.header.MinSizeOfStdStream,
+		TotalSectors:     cFile.header.TotalSectors,
+		RootCLSID:        decodeUUID(cFile.rootDirectoryEntry.CLSID[:]),
+		DirectoryEntries: entries,
+	}
 }
 
-func (cFile *defaultCompoundFile) setHeader(header []byte) CompoundFile {
-	cFile.header = header
-	return cFile
+// Fprint writes a human-readable rendering of info to w
+func Fprint(w io.Writer, info FileInfo) {
+	fmt.Fprintf(w, "FileIdentifier = % x\n", info.FileIdentifier)
+	fmt.Fprintf(w, "UUID = %s\n", info.UUID)
+	fmt.Fprintf(w, "Revision = %d\n", info.Revision)
+	fmt.Fprintf(w, "Version = %d\n", info.Version)
+	fmt.Fprintf(w, "LittleEndian = %t\n", info.LittleEndian)
+	fmt.Fprintf(w, "SectorSize = %d\n", info.SectorSize)
+	fmt.Fprintf(w, "MiniSectorSize = %d\n", info.MiniSectorSize)
+	fmt.Fprintf(w, "MiniStreamCutoff = %d\n", info.MiniStreamCutoff)
+	fmt.Fprintf(w, "TotalSectors = %d\n", info.TotalSectors)
+	fmt.Fprintf(w, "RootCLSID = %s\n", info.RootCLSID)
+	for _, entry := range info.DirectoryEntries {
+		fmt.Fprintf(w, "  %v %s (%d bytes)\n", entry.Path, entry.Name, entry.Size)
+	}
 }
+
 func (cFile *defaultCompoundFile) setFilename(filepath string) CompoundFile {
 	cFile.filename = filepath
 	return cFile
 }
-func (cFile *defaultCompoundFile) setRootDirectory(rootDirectory []byte) CompoundFile {
-	cFile.rootDirectoryEntry = rootDirectory
-	return cFile
-}
 
-//NewCompoundFile returns the default implmentation of the compound file
+// NewCompoundFile returns the default implmentation of the compound file,
+// reading it from the file at filepath
 func NewCompoundFile(filepath string) (CompoundFile, error) {
-	var err error
-	var file *os.File
-	var bytesRead []byte
-	var cfile defaultCompoundFileInterface = &defaultCompoundFile{filename: filepath}
-	if file, err = os.Open(filepath); err != nil {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	cfile, err := NewCompoundFileFromReader(file, info.Size())
+	if err != nil {
+		file.Close()
 		return nil, err
 	}
-	defer file.Close()
+	cfile.(defaultCompoundFileInterface).setFilename(filepath)
+	return cfile, nil
+}
+
+// NewCompoundFileFromReader returns the default implementation of the
+// compound file, parsed out of r. size must be the total number of bytes
+// available in r, used to bounds-check sector reads. If r also implements
+// io.Closer, CompoundFile.Close() closes it.
+func NewCompoundFileFromReader(r io.ReaderAt, size int64) (CompoundFile, error) {
 	//Header always starts at offset 0 and is of size 512
-	if bytesRead, err = read(file, 0, 512); err != nil {
+	headerBytes, err := read(r, 0, 512)
+	if err != nil {
 		return nil, err
 	}
-	cfile.setHeader(bytesRead)
-	if !validateFileIdentifier(cfile.getValueFromHeader("FileIdentifier")) {
-		err = errors.New("Invalid File Identtifier")
+	header, littleEndian, err := decodeHeader(headerBytes)
+	if err != nil {
 		return nil, err
 	}
-	littleEndian := cfile.isLittleEndian()
-	sectorID := decodeValueAsUInt16(littleEndian, cfile.getValueFromHeader("FirstSectorID"))
-	sectorSize := decodeValueAsUInt16(littleEndian, cfile.getValueFromHeader("SizeOfSector"))
-	sectorPosition := getSectorPosition(sectorID, sectorSize)
+	if !validateFileIdentifier(header.FileIdentifier[:]) {
+		return nil, errors.New("Invalid File Identtifier")
+	}
 
-	//Sector is always of size 128
-	if bytesRead, err = read(file, int64(sectorPosition), 128); err != nil {
+	sectorPosition := getSectorPosition(header.FirstSectorID, header.SizeOfSector)
+	rootBytes, err := read(r, int64(sectorPosition), directoryEntrySize)
+	if err != nil {
 		return nil, err
 	}
-	cfile.setRootDirectory(bytesRead)
-	if !validateRootDirectoryType(cfile.getValueFromRootDirectory("Type")) {
+	root, err := decodeDirectoryEntry(rootBytes, byteOrder(littleEndian))
+	if err != nil {
+		return nil, err
+	}
+	if !validateRootDirectoryType(root.Type) {
 		return nil, errors.New("Invalid type found while validating root directory. Not a compound file")
 	}
 
-	return cfile, err
+	return &defaultCompoundFile{
+		source:             r,
+		size:               size,
+		header:             *header,
+		littleEndian:       littleEndian,
+		byteOrder:          byteOrder(littleEndian),
+		rootDirectoryEntry: *root,
+	}, nil
+}
+
+// decodeHeader decodes the 512-byte compound file header, returning it along
+// with whether the file declares itself little-endian
+func decodeHeader(raw []byte) (*cfbHeader, bool, error) {
+	if len(raw) != 512 {
+		return nil, false, fmt.Errorf("msgime: header must be exactly 512 bytes, got %d", len(raw))
+	}
+	littleEndian := raw[28] == 0xFE
+	var h cfbHeader
+	if err := binary.Read(bytes.NewReader(raw), byteOrder(littleEndian), &h); err != nil {
+		return nil, false, err
+	}
+	return &h, littleEndian, nil
+}
+
+// byteOrder returns the binary.ByteOrder a compound file's multi-byte fields
+// are encoded in, given the header's ByteOrderIdentifier
+func byteOrder(littleEndian bool) binary.ByteOrder {
+	if littleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
 }
 
 func validateFileIdentifier(fileIdentifier []byte) bool {
@@ -201,100 +287,46 @@ func validateFileIdentifier(fileIdentifier []byte) bool {
 	return valid
 }
 
-func validateRootDirectoryType(rootDirectoryType []byte) bool {
-	return rootDirectoryType[0] == 5
+func validateRootDirectoryType(rootDirectoryType byte) bool {
+	return rootDirectoryType == direntTypeRoot
 }
 
-func read(file *os.File, offset int64, size int) ([]byte, error) {
+func read(source io.ReaderAt, offset int64, size int) ([]byte, error) {
 	var buffer = make([]byte, size)
-	file.Seek(offset, io.SeekStart)
-	bytesRead, err := io.ReadFull(file, buffer)
+	bytesRead, err := source.ReadAt(buffer, offset)
+	if bytesRead == size {
+		//ReadAt may legitimately report io.EOF even after filling buffer in full
+		return buffer, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	if bytesRead != size {
-		err = fmt.Errorf("Unable to read %d bytes. File may be corrupted or not a compound file", size)
-	}
-	return buffer, err
-}
-
-func decodeValueAsUUID(littleEndian bool, value []byte) string {
-	//Microsoft uses mixed endian https://en.wikipedia.org/wiki/Universally_unique_identifier
-	//So we will ignore the flag and decode first 3 components as little endian and last 2 components as big endian
-	var bytes1To4 = decodeValueAsUInt64(true, value[0:4])
-	var bytes5To6 = decodeValueAsUInt64(true, value[4:6])
-	var bytes7To8 = decodeValueAsUInt64(true, value[6:8])
-	var bytes9To10 = decodeValueAsUInt64(false, value[8:10])
-	var bytes11To16 = decodeValueAsUInt64(false, value[10:16])
-
-	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", bytes1To4, bytes5To6, bytes7To8, bytes9To10, bytes11To16)
-}
-
-func decodeValueAsUInt64(littleEndian bool, value []byte) uint64 {
-	//Not using binary.littleendian.Uint16 because it expects the value to be 8 byte only
-	var returnValue uint64
-	for i := 0; i < len(value); i++ {
-		if littleEndian {
-			returnValue = (returnValue << 8) | uint64(value[len(value)-(i+1)])
-		} else {
-			returnValue = (returnValue << 8) | uint64(value[i])
-		}
-	}
-	return returnValue
-}
-
-func decodeValueAsUInt16(littleEndian bool, value []byte) uint16 {
-	//Not using binary.littleendian.Uint16 because it expects the value to be 2 byte only
-	var returnValue uint16
-	for i := 0; i < len(value); i++ {
-		if littleEndian {
-			returnValue = (returnValue << 8) | uint16(value[len(value)-(i+1)])
-		} else {
-			returnValue = (returnValue << 8) | uint16(value[i])
-		}
-	}
-	return returnValue
+	return nil, fmt.Errorf("Unable to read %d bytes. File may be corrupted or not a compound file", size)
 }
 
-func decodeValueAsByteArray(littleEndian bool, value []byte) []byte {
-	var returnValue = value
-	if littleEndian {
-		returnValue = make([]byte, len(value))
-		copy(returnValue, value)
-		for index, byteValue := range value {
-			returnValue[len(value)-(index+1)] = byteValue
-		}
-	}
-	return returnValue
+// decodeUUID formats a 16-byte CLSID/GUID value as a UUID string. Microsoft
+// uses mixed endian (https://en.wikipedia.org/wiki/Universally_unique_identifier):
+// the first three components are little-endian, the last two are big-endian,
+// regardless of the compound file's own declared byte order
+func decodeUUID(value []byte) string {
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x",
+		binary.LittleEndian.Uint32(value[0:4]),
+		binary.LittleEndian.Uint16(value[4:6]),
+		binary.LittleEndian.Uint16(value[6:8]),
+		binary.BigEndian.Uint16(value[8:10]),
+		bigEndianUint48(value[10:16]))
 }
 
-func printValue(fieldname string, value []byte) {
-	fmt.Printf("%s = ", fieldname)
-	for _, byteValue := range value {
-		fmt.Printf("%02x ", byteValue)
+// bigEndianUint48 decodes a 6-byte big-endian value; encoding/binary doesn't
+// provide this width directly
+func bigEndianUint48(value []byte) uint64 {
+	var result uint64
+	for _, b := range value {
+		result = result<<8 | uint64(b)
 	}
-	fmt.Println()
-}
-
-func getSectorPosition(sectorID uint16, sectorSize uint16) uint64 {
-	return 512 + uint64(sectorID)*calcPower(2, sectorSize)
+	return result
 }
 
-func calcPower(x uint16, y uint16) uint64 {
-	//Not using golang math as it returns float and don't want to even deal with possibilites of precision issues due to using float instead of an int
-	if y == 0 {
-		return 1
-	}
-	var result uint64 = 1
-	var multiplier = uint64(x)
-	for i := y; i > 1; {
-		if y%2 == 0 {
-			multiplier = multiplier * multiplier
-			i = i / 2
-		} else {
-			result = result * multiplier
-			i = i - 1
-		}
-	}
-	return result * multiplier
+func getSectorPosition(sectorID uint32, sectorSizeExponent uint16) uint64 {
+	return (uint64(sectorID) + 1) * (uint64(1) << sectorSizeExponent)
 }
@@ -0,0 +1,298 @@
+// Package olepops parses OLE Property Set streams ([MS-OLEPS]), the format
+// used by the \x05SummaryInformation and \x05DocumentSummaryInformation
+// streams of a compound file to carry document metadata such as title,
+// author and application name.
+package olepops
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Variant type codes used in OLE property set streams, see [MS-OLEPS] 2.15
+const (
+	vtEmpty    = 0
+	vtI2       = 2
+	vtI4       = 3
+	vtBool     = 11
+	vtLPSTR    = 30
+	vtLPWSTR   = 31
+	vtFileTime = 64
+	vtCF       = 71
+)
+
+// Well-known property IDs of the \x05SummaryInformation stream, see [MS-OLEPS] 2.18.1
+const (
+	PIDSITitle         = 0x02
+	PIDSISubject       = 0x03
+	PIDSIAuthor        = 0x04
+	PIDSIKeywords      = 0x05
+	PIDSIComments      = 0x06
+	PIDSILastAuthor    = 0x08
+	PIDSIRevNumber     = 0x09
+	PIDSIEditTime      = 0x0A
+	PIDSICreateTime    = 0x0C
+	PIDSILastSavedTime = 0x0D
+	PIDSIPageCount     = 0x0E
+	PIDSIWordCount     = 0x0F
+	PIDSICharCount     = 0x10
+	PIDSIAppName       = 0x12
+)
+
+// byteOrderMarker is the constant value of a property set's ByteOrder header field
+const byteOrderMarker = 0xFFFE
+
+// FileTime is a raw Windows FILETIME value (100ns intervals), used by
+// properties that represent either an absolute timestamp or an elapsed duration
+type FileTime uint64
+
+// Time interprets the FileTime as 100ns intervals since 1601-01-01
+func (ft FileTime) Time() time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	//Number of 100ns intervals between the FILETIME epoch (1601-01-01) and the Unix epoch
+	const filetimeToUnixEpoch = 116444736000000000
+	return time.Unix(0, int64(uint64(ft)-filetimeToUnixEpoch)*100).UTC()
+}
+
+// Duration interprets the FileTime as a plain count of 100ns intervals
+func (ft FileTime) Duration() time.Duration {
+	return time.Duration(uint64(ft) * 100)
+}
+
+// PropertySet holds the decoded well-known properties of a SummaryInformation
+// stream, with every raw property also available keyed by PID for less common
+// fields. The typed fields are filled by their SummaryInformation PIDs in
+// every section, whatever the section's FMTID, so for a
+// DocumentSummaryInformation stream only Properties is meaningful.
+type PropertySet struct {
+	Title            string
+	Subject          string
+	Author           string
+	Keywords         string
+	Comments         string
+	LastSavedBy      string
+	RevisionNumber   string
+	TotalEditingTime time.Duration
+	CreateDTM        time.Time
+	LastSavedDTM     time.Time
+	AppName          string
+	WordCount        uint32
+	PageCount        uint32
+	CharCount        uint32
+
+	//Properties holds every decoded property value across every section, keyed by PID
+	Properties map[uint32]interface{}
+}
+
+// Parse decodes an OLE Property Set stream, such as the content of a
+// \x05SummaryInformation or \x05DocumentSummaryInformation entry
+func Parse(r io.Reader) (*PropertySet, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 28 {
+		return nil, errors.New("olepops: stream too short for a property set header")
+	}
+	if byteOrder := binary.LittleEndian.Uint16(data[0:2]); byteOrder != byteOrderMarker {
+		return nil, fmt.Errorf("olepops: unexpected byte order marker %#04x", byteOrder)
+	}
+	numSections := binary.LittleEndian.Uint32(data[24:28])
+
+	ps := &PropertySet{Properties: map[uint32]interface{}{}}
+
+	offset := 28
+	for i := uint32(0); i < numSections; i++ {
+		if offset+20 > len(data) {
+			return nil, errors.New("olepops: truncated section descriptor list")
+		}
+		//FMTID occupies data[offset:offset+16], not currently used to
+		//distinguish one section's properties from another's
+		sectionOffset := binary.LittleEndian.Uint32(data[offset+16 : offset+20])
+		if err := ps.parseSection(data, int(sectionOffset)); err != nil {
+			return nil, err
+		}
+		offset += 20
+	}
+
+	return ps, nil
+}
+
+// parseSection decodes every property in the section starting at sectionStart
+// and folds well-known ones into the typed PropertySet fields
+func (ps *PropertySet) parseSection(data []byte, sectionStart int) error {
+	if sectionStart < 0 || sectionStart+8 > len(data) {
+		return errors.New("olepops: section header out of range")
+	}
+	cProperties := binary.LittleEndian.Uint32(data[sectionStart+4 : sectionStart+8])
+
+	cursor := sectionStart + 8
+	for i := uint32(0); i < cProperties; i++ {
+		if cursor+8 > len(data) {
+			return errors.New("olepops: property ID/offset table out of range")
+		}
+		id := binary.LittleEndian.Uint32(data[cursor : cursor+4])
+		propOffset := binary.LittleEndian.Uint32(data[cursor+4 : cursor+8])
+		cursor += 8
+
+		value, err := decodeProperty(data, sectionStart+int(propOffset))
+		if err != nil {
+			return fmt.Errorf("olepops: decoding property %#x: %w", id, err)
+		}
+		ps.Properties[id] = value
+		ps.applyWellKnown(id, value)
+	}
+	return nil
+}
+
+// decodeProperty reads a property's 4-byte variant type code followed by its
+// type-specific value, starting at offset
+func decodeProperty(data []byte, offset int) (interface{}, error) {
+	if offset < 0 || offset+4 > len(data) {
+		return nil, errors.New("property value out of range")
+	}
+	vt := binary.LittleEndian.Uint32(data[offset : offset+4])
+	value := offset + 4
+
+	switch vt {
+	case vtEmpty:
+		return nil, nil
+	case vtI2:
+		if value+2 > len(data) {
+			return nil, errors.New("truncated VT_I2")
+		}
+		return int16(binary.LittleEndian.Uint16(data[value : value+2])), nil
+	case vtI4:
+		if value+4 > len(data) {
+			return nil, errors.New("truncated VT_I4")
+		}
+		return int32(binary.LittleEndian.Uint32(data[value : value+4])), nil
+	case vtBool:
+		if value+2 > len(data) {
+			return nil, errors.New("truncated VT_BOOL")
+		}
+		return binary.LittleEndian.Uint16(data[value:value+2]) != 0, nil
+	case vtFileTime:
+		if value+8 > len(data) {
+			return nil, errors.New("truncated VT_FILETIME")
+		}
+		return FileTime(binary.LittleEndian.Uint64(data[value : value+8])), nil
+	case vtLPSTR:
+		return decodeLPSTR(data, value)
+	case vtLPWSTR:
+		return decodeLPWSTR(data, value)
+	case vtCF:
+		return decodeCF(data, value)
+	default:
+		return nil, fmt.Errorf("unsupported variant type %#x", vt)
+	}
+}
+
+// decodeLPSTR reads a VT_LPSTR: a 4-byte byte count (including the null
+// terminator) followed by the ANSI string data
+func decodeLPSTR(data []byte, offset int) (string, error) {
+	if offset+4 > len(data) {
+		return "", errors.New("truncated VT_LPSTR length")
+	}
+	length := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
+	start := offset + 4
+	end := start + length
+	if length < 0 || end > len(data) {
+		return "", errors.New("truncated VT_LPSTR data")
+	}
+	return strings.TrimRight(string(data[start:end]), "\x00"), nil
+}
+
+// decodeLPWSTR reads a VT_LPWSTR: a 4-byte character count (including the
+// null terminator) followed by UTF-16 string data
+func decodeLPWSTR(data []byte, offset int) (string, error) {
+	if offset+4 > len(data) {
+		return "", errors.New("truncated VT_LPWSTR length")
+	}
+	charCount := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
+	start := offset + 4
+	end := start + charCount*2
+	if charCount < 0 || end > len(data) {
+		return "", errors.New("truncated VT_LPWSTR data")
+	}
+	chars := make([]uint16, charCount)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(data[start+i*2 : start+i*2+2])
+	}
+	return strings.TrimRight(string(utf16.Decode(chars)), "\x00"), nil
+}
+
+// decodeCF reads a VT_CF (clipboard format) blob: a 4-byte byte count
+// followed by the raw clipboard data, returned unparsed
+func decodeCF(data []byte, offset int) ([]byte, error) {
+	if offset+4 > len(data) {
+		return nil, errors.New("truncated VT_CF length")
+	}
+	length := int(binary.LittleEndian.Uint32(data[offset : offset+4]))
+	start := offset + 4
+	end := start + length
+	if length < 0 || end > len(data) {
+		return nil, errors.New("truncated VT_CF data")
+	}
+	return data[start:end], nil
+}
+
+// applyWellKnown folds a decoded property into its typed PropertySet field,
+// when it's one we recognize
+func (ps *PropertySet) applyWellKnown(id uint32, value interface{}) {
+	switch id {
+	case PIDSITitle:
+		ps.Title, _ = value.(string)
+	case PIDSISubject:
+		ps.Subject, _ = value.(string)
+	case PIDSIAuthor:
+		ps.Author, _ = value.(string)
+	case PIDSIKeywords:
+		ps.Keywords, _ = value.(string)
+	case PIDSIComments:
+		ps.Comments, _ = value.(string)
+	case PIDSILastAuthor:
+		ps.LastSavedBy, _ = value.(string)
+	case PIDSIRevNumber:
+		ps.RevisionNumber, _ = value.(string)
+	case PIDSIEditTime:
+		if ft, ok := value.(FileTime); ok {
+			ps.TotalEditingTime = ft.Duration()
+		}
+	case PIDSICreateTime:
+		if ft, ok := value.(FileTime); ok {
+			ps.CreateDTM = ft.Time()
+		}
+	case PIDSILastSavedTime:
+		if ft, ok := value.(FileTime); ok {
+			ps.LastSavedDTM = ft.Time()
+		}
+	case PIDSIPageCount:
+		ps.PageCount = toUint32(value)
+	case PIDSIWordCount:
+		ps.WordCount = toUint32(value)
+	case PIDSICharCount:
+		ps.CharCount = toUint32(value)
+	case PIDSIAppName:
+		ps.AppName, _ = value.(string)
+	}
+}
+
+// toUint32 extracts an unsigned count out of the signed integer variant types
+// property sets use for counters
+func toUint32(value interface{}) uint32 {
+	switch v := value.(type) {
+	case int32:
+		return uint32(v)
+	case int16:
+		return uint32(v)
+	}
+	return 0
+}
@@ -0,0 +1,108 @@
+package olepops
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// property describes a single property value to embed via buildPropertySet
+type property struct {
+	vt  uint32
+	str string
+	i4  int32
+}
+
+// buildPropertySet assembles a minimal single-section [MS-OLEPS] property set
+// stream holding the given properties, keyed by PID
+func buildPropertySet(t *testing.T, props map[uint32]property) []byte {
+	t.Helper()
+
+	ids := make([]uint32, 0, len(props))
+	for id := range props {
+		ids = append(ids, id)
+	}
+
+	//Encode every property value first so the ID/offset table, whose offsets
+	//are relative to the start of the section, can be computed up front
+	var values bytes.Buffer
+	offsets := make(map[uint32]uint32, len(props))
+	tableSize := uint32(8 + 8*len(props)) //section size + count, then one ID/offset pair per property
+	for _, id := range ids {
+		offsets[id] = tableSize + uint32(values.Len())
+		p := props[id]
+		mustWrite(t, binary.Write(&values, binary.LittleEndian, p.vt))
+		switch p.vt {
+		case vtLPSTR:
+			str := append([]byte(p.str), 0)
+			mustWrite(t, binary.Write(&values, binary.LittleEndian, uint32(len(str))))
+			values.Write(str)
+		case vtI4:
+			mustWrite(t, binary.Write(&values, binary.LittleEndian, p.i4))
+		default:
+			t.Fatalf("buildPropertySet: unsupported vt %#x in test fixture", p.vt)
+		}
+	}
+
+	var buf bytes.Buffer
+	//Header: ByteOrder, Format, OSVersion, ClassID, SectionCount
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint16(byteOrderMarker)))
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint16(0)))
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(0)))
+	buf.Write(make([]byte, 16))
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(1)))
+
+	//Section descriptor: FMTID (unused) + the section's absolute offset
+	buf.Write(make([]byte, 16))
+	sectionOffset := uint32(buf.Len() + 4)
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, sectionOffset))
+
+	//Section: size (unused) + property count + one ID/offset pair per property
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(0)))
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(len(ids))))
+	for _, id := range ids {
+		mustWrite(t, binary.Write(&buf, binary.LittleEndian, id))
+		mustWrite(t, binary.Write(&buf, binary.LittleEndian, offsets[id]))
+	}
+	buf.Write(values.Bytes())
+
+	return buf.Bytes()
+}
+
+func mustWrite(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseExtractsWellKnownProperties(t *testing.T) {
+	data := buildPropertySet(t, map[uint32]property{
+		PIDSITitle:     {vt: vtLPSTR, str: "My Document"},
+		PIDSIAppName:   {vt: vtLPSTR, str: "Microsoft Word 97-2003"},
+		PIDSIPageCount: {vt: vtI4, i4: 7},
+	})
+
+	ps, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if ps.Title != "My Document" {
+		t.Errorf("Title = %q, want %q", ps.Title, "My Document")
+	}
+	if ps.AppName != "Microsoft Word 97-2003" {
+		t.Errorf("AppName = %q, want %q", ps.AppName, "Microsoft Word 97-2003")
+	}
+	if ps.PageCount != 7 {
+		t.Errorf("PageCount = %d, want 7", ps.PageCount)
+	}
+}
+
+func TestParseRejectsBadByteOrderMarker(t *testing.T) {
+	data := buildPropertySet(t, map[uint32]property{PIDSITitle: {vt: vtLPSTR, str: "x"}})
+	data[0] = 0x00 //corrupt the byte order marker
+
+	if _, err := Parse(bytes.NewReader(data)); err == nil {
+		t.Fatal("Parse with a corrupt byte order marker: want error, got nil")
+	}
+}
@@ -0,0 +1,175 @@
+package msgime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// TestSectorByteSize exercises sectorByteSize and shortSectorByteSize across
+// the exponents used by real CFBv3 (9, 6) and CFBv4 (12) files, guarding
+// against a power-of-two computation that returns the wrong byte size for
+// some exponents.
+func TestSectorByteSize(t *testing.T) {
+	cases := []struct {
+		exponent uint16
+		want     uint16
+	}{
+		{6, 64},
+		{9, 512},
+		{10, 1024},
+		{12, 4096},
+	}
+	for _, c := range cases {
+		cFile := &defaultCompoundFile{header: cfbHeader{SizeOfSector: c.exponent, SizeOfShortSector: c.exponent}}
+		if got := cFile.sectorByteSize(); got != c.want {
+			t.Errorf("sectorByteSize() with exponent %d = %d, want %d", c.exponent, got, c.want)
+		}
+		if got := cFile.shortSectorByteSize(); got != c.want {
+			t.Errorf("shortSectorByteSize() with exponent %d = %d, want %d", c.exponent, got, c.want)
+		}
+	}
+}
+
+// TestGetSectorPosition pins the header-to-sector-offset math for both
+// sector sizes the format supports. Per [MS-CFB] 2.5, sector 0 starts right
+// after the header, which occupies exactly one sector's worth of space, so
+// sector N is at (N+1)*sectorSize -- not the 512-byte-header-relative offset
+// that only happens to be correct for 512-byte (CFBv3) sectors.
+func TestGetSectorPosition(t *testing.T) {
+	cases := []struct {
+		sectorID uint32
+		exponent uint16
+		want     uint64
+	}{
+		{0, 9, 512},
+		{1, 9, 1024},
+		{0, 12, 4096},
+		{1, 12, 8192},
+	}
+	for _, c := range cases {
+		if got := getSectorPosition(c.sectorID, c.exponent); got != c.want {
+			t.Errorf("getSectorPosition(%d, %d) = %d, want %d", c.sectorID, c.exponent, got, c.want)
+		}
+	}
+}
+
+// buildSynthCFBv4 assembles a minimal, valid CFBv4 (4096-byte sector)
+// compound file in memory holding a single small stream in the mini-stream.
+// Unlike buildSynthCFB (512-byte CFBv3 sectors), the header here is followed
+// by padding out to a full sector before sector 0 begins, exercising the
+// sector-offset formula at a size where getting it wrong actually matters.
+func buildSynthCFBv4(t *testing.T, name string, payload []byte) []byte {
+	t.Helper()
+	const sectorSize = 4096
+	const shortSectorSize = 64
+	const fatSector = 0
+	const dirSector = 1
+	const ssatSector = 2
+	const miniStreamFirstSector = 3
+
+	if len(payload) > shortSectorSize {
+		t.Fatalf("buildSynthCFBv4: payload must fit in a single short sector")
+	}
+
+	header := cfbHeader{
+		FileIdentifier:      [8]byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1},
+		RevisionNumber:      0x003E,
+		VersionNumber:       4,
+		ByteOrderIdentifier: 0xFFFE,
+		SizeOfSector:        12,
+		SizeOfShortSector:   6,
+		TotalSectors:        4,
+		FirstSectorID:       dirSector,
+		MinSizeOfStdStream:  4096,
+		FirstShortSectorID:  ssatSector,
+		TotalSectorsUsedForShortSectorAllocationTable: 1,
+		FistMasterSectorID:                            sectorEndOfChain,
+	}
+	header.FirstPartOfMasterAllocationTable[0] = fatSector
+	for i := 1; i < len(header.FirstPartOfMasterAllocationTable); i++ {
+		header.FirstPartOfMasterAllocationTable[i] = sectorFreeSect
+	}
+
+	root := directoryEntry{
+		Type:                   direntTypeRoot,
+		DirIDOfLeftChild:       noStream,
+		DirIDOfRighttChild:     noStream,
+		DirIDOfRoot:            1,
+		FistSectorID:           miniStreamFirstSector,
+		TotalStreamSizeInBytes: shortSectorSize,
+	}
+	setEntryName(&root, "Root Entry")
+
+	stream := directoryEntry{
+		Type:                   direntTypeStream,
+		DirIDOfLeftChild:       noStream,
+		DirIDOfRighttChild:     noStream,
+		DirIDOfRoot:            noStream,
+		FistSectorID:           0,
+		TotalStreamSizeInBytes: uint32(len(payload)),
+	}
+	setEntryName(&stream, name)
+
+	var buf bytes.Buffer
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, &header))
+	buf.Write(make([]byte, sectorSize-512)) //pad the header out to a full sector
+
+	//Sector 0: FAT
+	fat := make([]uint32, sectorSize/4)
+	for i := range fat {
+		fat[i] = sectorFreeSect
+	}
+	fat[dirSector] = sectorEndOfChain
+	fat[ssatSector] = sectorEndOfChain
+	fat[miniStreamFirstSector] = sectorEndOfChain
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, fat))
+
+	//Sector 1: directory
+	entriesPerSector := sectorSize / directoryEntrySize
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, &root))
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, &stream))
+	var empty directoryEntry
+	for i := 2; i < entriesPerSector; i++ {
+		mustWrite(t, binary.Write(&buf, binary.LittleEndian, &empty))
+	}
+
+	//Sector 2: mini-FAT (SSAT)
+	ssat := make([]uint32, sectorSize/4)
+	for i := range ssat {
+		ssat[i] = sectorFreeSect
+	}
+	ssat[0] = sectorEndOfChain
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, ssat))
+
+	//Sector 3: mini-stream container
+	miniStream := make([]byte, sectorSize)
+	copy(miniStream, payload)
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, miniStream))
+
+	return buf.Bytes()
+}
+
+// TestSmallStreamRoundTripCFBv4 is the CFBv4 analogue of
+// TestSmallStreamRoundTrip: with 512-byte sectors, a header-relative sector
+// offset formula and the correct (N+1)*sectorSize one agree, so that test
+// alone can't catch a regression here.
+func TestSmallStreamRoundTripCFBv4(t *testing.T) {
+	payload := []byte("v4 ok")
+	data := buildSynthCFBv4(t, "Small", payload)
+
+	cfile, err := NewCompoundFileFromReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewCompoundFileFromReader: %v", err)
+	}
+	entry := findEntry(t, cfile, "Small")
+
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(entry, got); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(got) != string(payload) {
+		t.Fatalf("stream content = %q, want %q", got, payload)
+	}
+}
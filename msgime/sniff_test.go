@@ -0,0 +1,66 @@
+package msgime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/asheeshrana/gime/msgime/olepops"
+)
+
+// buildSummaryInfoPropertySet assembles a minimal [MS-OLEPS] property set
+// stream with a single PIDSI_APPNAME property, the shape of a real
+// \x05SummaryInformation stream
+func buildSummaryInfoPropertySet(t *testing.T, appName string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+
+	//Header: ByteOrder, Format, OSVersion, ClassID, SectionCount
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint16(0xFFFE)))
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint16(0)))
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(0)))
+	buf.Write(make([]byte, 16))                                      //ClassID, unused by olepops.Parse
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(1))) //one section
+
+	//Section descriptor: FMTID (unused) + the section's absolute offset
+	buf.Write(make([]byte, 16))
+	sectionOffset := uint32(buf.Len() + 4)
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, sectionOffset))
+
+	//Section: size (unused) + property count + one PIDSI_APPNAME ID/offset pair
+	const propValueOffset = 16 //4(size)+4(count)+4(id)+4(offset)
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(0)))
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(1)))
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(olepops.PIDSIAppName)))
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(propValueOffset)))
+
+	//Property value: VT_LPSTR + length (including NUL) + ANSI string
+	str := append([]byte(appName), 0)
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(30))) //VT_LPSTR
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, uint32(len(str))))
+	buf.Write(str)
+
+	return buf.Bytes()
+}
+
+// TestDetectFormatFromAppNameThroughMiniStream exercises DetectFormat's
+// app-name fallback against a \x05SummaryInformation stream carried in the
+// mini-stream, which is how such streams are almost always stored in
+// practice, now that the mini-stream sector-size math is fixed.
+func TestDetectFormatFromAppNameThroughMiniStream(t *testing.T) {
+	summary := buildSummaryInfoPropertySet(t, "Microsoft Word 97-2003")
+	data := buildSynthCFB(t, []testStream{{name: "\x05SummaryInformation", payload: summary}})
+
+	cfile, err := NewCompoundFileFromReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewCompoundFileFromReader: %v", err)
+	}
+
+	format := cfile.DetectFormat()
+	if format.Kind != FormatDOC {
+		t.Fatalf("DetectFormat().Kind = %v, want FormatDOC", format.Kind)
+	}
+	if format.Mime != "application/msword" {
+		t.Fatalf("DetectFormat().Mime = %q, want application/msword", format.Mime)
+	}
+}
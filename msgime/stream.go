@@ -0,0 +1,195 @@
+package msgime
+
+import (
+	"errors"
+	"io"
+)
+
+// Read implements io.Reader, reading sequentially from the start of the stream
+func (e *Entry) Read(p []byte) (int, error) {
+	n, err := e.ReadAt(p, e.pos)
+	e.pos += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt, reading stream content at an arbitrary offset.
+// Streams below MinSizeOfStdStream are served out of the mini-stream
+// container via the mini-FAT (SSAT); larger streams are read directly via the
+// regular FAT.
+func (e *Entry) ReadAt(p []byte, off int64) (int, error) {
+	if e.Type != EntryTypeStream {
+		return 0, errors.New("msgime: entry is not a stream")
+	}
+	if off < 0 {
+		return 0, errors.New("msgime: negative offset")
+	}
+	if off >= int64(e.Size) {
+		return 0, io.EOF
+	}
+
+	if !e.dataLoaded {
+		data, err := e.cfile.readEntryStream(e.firstSectorID, e.Size)
+		if err != nil {
+			return 0, err
+		}
+		e.data = data
+		e.dataLoaded = true
+	}
+	//e.data can be shorter than e.Size if the directory entry's declared size
+	//doesn't match the sectors actually reachable through its chain
+	if off >= int64(len(e.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, e.data[off:])
+	if int64(n)+off >= int64(len(e.data)) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// readEntryStream materializes a stream's content, dispatching to the
+// mini-stream (SSAT) or regular sector chain (FAT) depending on its size
+func (cFile *defaultCompoundFile) readEntryStream(firstSectorID uint32, size uint64) ([]byte, error) {
+	if err := cFile.ensureFAT(); err != nil {
+		return nil, err
+	}
+	if size < cFile.miniStreamCutoff() {
+		return cFile.readStreamBySSAT(firstSectorID, size)
+	}
+	return cFile.readStreamBySAT(firstSectorID, size)
+}
+
+// readStreamBySAT reads a stream's content by following its chain of regular,
+// SizeOfSector-sized sectors through the FAT
+func (cFile *defaultCompoundFile) readStreamBySAT(firstSectorID uint32, size uint64) ([]byte, error) {
+	visited := make(map[uint32]bool)
+	var data []byte
+	sectorID := firstSectorID
+	for uint64(len(data)) < size && sectorID != sectorEndOfChain && sectorID != noStream {
+		if visited[sectorID] {
+			return nil, errors.New("msgime: cycle detected while reading stream sector chain")
+		}
+		visited[sectorID] = true
+
+		sector, err := cFile.readSector(sectorID)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, sector...)
+
+		next, err := cFile.fatEntry(sectorID)
+		if err != nil {
+			return nil, err
+		}
+		sectorID = next
+	}
+	if uint64(len(data)) > size {
+		data = data[:size]
+	}
+	return data, nil
+}
+
+// readStreamBySSAT reads a stream's content out of the mini-stream container,
+// following its chain of SizeOfShortSector-sized sectors through the SSAT
+func (cFile *defaultCompoundFile) readStreamBySSAT(firstSectorID uint32, size uint64) ([]byte, error) {
+	if err := cFile.ensureMiniStream(); err != nil {
+		return nil, err
+	}
+	shortSectorBytes := uint64(cFile.shortSectorByteSize())
+
+	visited := make(map[uint32]bool)
+	var data []byte
+	sectorID := firstSectorID
+	for uint64(len(data)) < size && sectorID != sectorEndOfChain && sectorID != noStream {
+		if visited[sectorID] {
+			return nil, errors.New("msgime: cycle detected while reading mini-stream sector chain")
+		}
+		visited[sectorID] = true
+
+		if int(sectorID) >= len(cFile.ssat) {
+			return nil, errors.New("msgime: short sector out of range of SSAT")
+		}
+		start := uint64(sectorID) * shortSectorBytes
+		end := start + shortSectorBytes
+		if start > uint64(len(cFile.miniStream)) {
+			return nil, errors.New("msgime: short sector out of range of mini-stream container")
+		}
+		if end > uint64(len(cFile.miniStream)) {
+			end = uint64(len(cFile.miniStream))
+		}
+		data = append(data, cFile.miniStream[start:end]...)
+
+		sectorID = cFile.ssat[sectorID]
+	}
+	if uint64(len(data)) > size {
+		data = data[:size]
+	}
+	return data, nil
+}
+
+// ensureMiniStream builds the SSAT and loads the mini-stream container (the
+// root entry's own stream, read through the regular FAT) on first use
+func (cFile *defaultCompoundFile) ensureMiniStream() error {
+	if cFile.miniStreamLoaded {
+		return nil
+	}
+	if err := cFile.buildSSAT(); err != nil {
+		return err
+	}
+
+	firstSectorID := cFile.rootDirectoryEntry.FistSectorID
+	size := cFile.decodeStreamSize(&cFile.rootDirectoryEntry)
+
+	data, err := cFile.readStreamBySAT(firstSectorID, size)
+	if err != nil {
+		return err
+	}
+	cFile.miniStream = data
+	cFile.miniStreamLoaded = true
+	return nil
+}
+
+// buildSSAT reads the mini-FAT, a chain of regular sectors (starting at the
+// header's FirstShortSectorID, linked through the regular FAT) each holding
+// short-sector-chain pointers analogous to the FAT itself
+func (cFile *defaultCompoundFile) buildSSAT() error {
+	entriesPerSector := int(cFile.sectorByteSize()) / 4
+
+	visited := make(map[uint32]bool)
+	var ssat []uint32
+	sectorID := cFile.header.FirstShortSectorID
+	for sectorID != sectorEndOfChain && sectorID != noStream {
+		if visited[sectorID] {
+			return errors.New("msgime: cycle detected while reading SSAT sector chain")
+		}
+		visited[sectorID] = true
+
+		data, err := cFile.readSector(sectorID)
+		if err != nil {
+			return err
+		}
+		for j := 0; j < entriesPerSector; j++ {
+			ssat = append(ssat, cFile.byteOrder.Uint32(data[j*4:j*4+4]))
+		}
+
+		next, err := cFile.fatEntry(sectorID)
+		if err != nil {
+			return err
+		}
+		sectorID = next
+	}
+	cFile.ssat = ssat
+	return nil
+}
+
+// shortSectorByteSize returns the size in bytes of a mini-stream (short) sector
+func (cFile *defaultCompoundFile) shortSectorByteSize() uint16 {
+	return uint16(1) << cFile.header.SizeOfShortSector
+}
+
+// miniStreamCutoff is the minimum stream size, in bytes, stored in regular
+// sectors rather than the mini-stream; smaller streams live in the mini-stream
+func (cFile *defaultCompoundFile) miniStreamCutoff() uint64 {
+	return uint64(cFile.header.MinSizeOfStdStream)
+}
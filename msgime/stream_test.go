@@ -0,0 +1,272 @@
+package msgime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"unicode/utf16"
+)
+
+// testStream describes one stream to embed in a synthetic compound file
+// built by buildSynthCFB
+type testStream struct {
+	name    string
+	payload []byte
+	//claimedSize overrides the directory entry's declared stream size; zero
+	//means use len(payload). Set higher than the payload to simulate a
+	//directory entry whose chain doesn't actually reach that many bytes.
+	claimedSize uint32
+}
+
+// buildSynthCFB assembles a minimal, valid CFBv3 compound file in memory
+// holding the given streams, every one small enough to live in the
+// mini-stream container. It's used to exercise the FAT/mini-FAT sector-size
+// math and mini-stream reads end to end, without needing a real fixture file.
+//
+// Sector layout: 0 = FAT, 1 = directory, 2 = mini-FAT (SSAT), 3.. = mini-stream
+func buildSynthCFB(t *testing.T, streams []testStream) []byte {
+	t.Helper()
+	const sectorSize = 512
+	const shortSectorSize = 64
+	const fatSector = 0
+	const dirSector = 1
+	const ssatSector = 2
+	const miniStreamFirstSector = 3
+
+	if len(streams)+1 > sectorSize/directoryEntrySize {
+		t.Fatalf("buildSynthCFB: too many streams for a single directory sector")
+	}
+
+	firstShortSector := make([]uint32, len(streams))
+	sectorsNeeded := make([]int, len(streams))
+	shortSectorCount := 0
+	for i, s := range streams {
+		n := (len(s.payload) + shortSectorSize - 1) / shortSectorSize
+		if n == 0 {
+			n = 1
+		}
+		firstShortSector[i] = uint32(shortSectorCount)
+		sectorsNeeded[i] = n
+		shortSectorCount += n
+	}
+	miniStreamBytes := shortSectorCount * shortSectorSize
+	miniStreamRegularSectors := (miniStreamBytes + sectorSize - 1) / sectorSize
+	if miniStreamRegularSectors < 1 {
+		miniStreamRegularSectors = 1
+	}
+
+	header := cfbHeader{
+		FileIdentifier:      [8]byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1},
+		RevisionNumber:      0x003E,
+		VersionNumber:       3,
+		ByteOrderIdentifier: 0xFFFE,
+		SizeOfSector:        9,
+		SizeOfShortSector:   6,
+		TotalSectors:        uint32(3 + miniStreamRegularSectors),
+		FirstSectorID:       dirSector,
+		MinSizeOfStdStream:  4096,
+		FirstShortSectorID:  ssatSector,
+		TotalSectorsUsedForShortSectorAllocationTable: 1,
+		FistMasterSectorID:                            sectorEndOfChain,
+	}
+	header.FirstPartOfMasterAllocationTable[0] = fatSector
+	for i := 1; i < len(header.FirstPartOfMasterAllocationTable); i++ {
+		header.FirstPartOfMasterAllocationTable[i] = sectorFreeSect
+	}
+
+	rootChild := uint32(noStream)
+	if len(streams) > 0 {
+		rootChild = 1
+	}
+	root := directoryEntry{
+		Type:                   direntTypeRoot,
+		DirIDOfLeftChild:       noStream,
+		DirIDOfRighttChild:     noStream,
+		DirIDOfRoot:            rootChild,
+		FistSectorID:           miniStreamFirstSector,
+		TotalStreamSizeInBytes: uint32(miniStreamBytes),
+	}
+	setEntryName(&root, "Root Entry")
+
+	entries := []directoryEntry{root}
+	for i, s := range streams {
+		size := s.claimedSize
+		if size == 0 {
+			size = uint32(len(s.payload))
+		}
+		entry := directoryEntry{
+			Type:                   direntTypeStream,
+			DirIDOfLeftChild:       noStream,
+			DirIDOfRighttChild:     noStream,
+			DirIDOfRoot:            noStream,
+			FistSectorID:           firstShortSector[i],
+			TotalStreamSizeInBytes: size,
+		}
+		setEntryName(&entry, s.name)
+		entries = append(entries, entry)
+	}
+	//chain every stream entry as the right child of the previous one, so the
+	//whole list hangs off the root's DirIDOfRoot as a degenerate search tree
+	for i := 1; i < len(entries)-1; i++ {
+		entries[i].DirIDOfRighttChild = uint32(i + 1)
+	}
+
+	var buf bytes.Buffer
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, &header))
+
+	//Sector 0: FAT
+	fat := make([]uint32, sectorSize/4)
+	for i := range fat {
+		fat[i] = sectorFreeSect
+	}
+	fat[dirSector] = sectorEndOfChain
+	fat[ssatSector] = sectorEndOfChain
+	for i := 0; i < miniStreamRegularSectors; i++ {
+		if i == miniStreamRegularSectors-1 {
+			fat[miniStreamFirstSector+i] = sectorEndOfChain
+		} else {
+			fat[miniStreamFirstSector+i] = uint32(miniStreamFirstSector + i + 1)
+		}
+	}
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, fat))
+
+	//Sector 1: directory
+	entriesPerSector := sectorSize / directoryEntrySize
+	for i := 0; i < entriesPerSector; i++ {
+		var e directoryEntry
+		if i < len(entries) {
+			e = entries[i]
+		}
+		mustWrite(t, binary.Write(&buf, binary.LittleEndian, &e))
+	}
+
+	//Sector 2: mini-FAT (SSAT)
+	ssat := make([]uint32, sectorSize/4)
+	for i := range ssat {
+		ssat[i] = sectorFreeSect
+	}
+	for i := range streams {
+		start := int(firstShortSector[i])
+		for j := 0; j < sectorsNeeded[i]; j++ {
+			if j == sectorsNeeded[i]-1 {
+				ssat[start+j] = sectorEndOfChain
+			} else {
+				ssat[start+j] = uint32(start + j + 1)
+			}
+		}
+	}
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, ssat))
+
+	//Sectors 3..: mini-stream container
+	miniStream := make([]byte, miniStreamRegularSectors*sectorSize)
+	for i, s := range streams {
+		copy(miniStream[int(firstShortSector[i])*shortSectorSize:], s.payload)
+	}
+	mustWrite(t, binary.Write(&buf, binary.LittleEndian, miniStream))
+
+	return buf.Bytes()
+}
+
+// setEntryName encodes name as UTF-16 into a directory entry's EntryName field
+func setEntryName(e *directoryEntry, name string) {
+	chars := utf16.Encode([]rune(name))
+	for i, c := range chars {
+		binary.LittleEndian.PutUint16(e.EntryName[i*2:i*2+2], c)
+	}
+	e.SizeOfEntryNameInCharacters = uint16((len(chars) + 1) * 2)
+}
+
+func mustWrite(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// findEntry walks cfile looking for an entry with the given name
+func findEntry(t *testing.T, cfile CompoundFile, name string) *Entry {
+	t.Helper()
+	for {
+		e, err := cfile.Next()
+		if err == io.EOF {
+			t.Fatalf("entry %q not found", name)
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if e.Name == name {
+			return e
+		}
+	}
+}
+
+func TestSmallStreamRoundTrip(t *testing.T) {
+	payload := []byte("hello cfb!")
+	data := buildSynthCFB(t, []testStream{{name: "Small", payload: payload}})
+
+	cfile, err := NewCompoundFileFromReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewCompoundFileFromReader: %v", err)
+	}
+
+	entry := findEntry(t, cfile, "Small")
+
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(entry, got); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(got) != string(payload) {
+		t.Fatalf("stream content = %q, want %q", got, payload)
+	}
+}
+
+// TestEntryReadAtReusesCachedData reads the same entry at two different
+// offsets and checks both reads agree, guarding against the cache getting
+// invalidated or served stale across calls.
+func TestEntryReadAtReusesCachedData(t *testing.T) {
+	payload := []byte("hello cfb!")
+	data := buildSynthCFB(t, []testStream{{name: "Small", payload: payload}})
+
+	cfile, err := NewCompoundFileFromReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewCompoundFileFromReader: %v", err)
+	}
+	entry := findEntry(t, cfile, "Small")
+
+	first := make([]byte, 3)
+	if _, err := entry.ReadAt(first, 0); err != nil {
+		t.Fatalf("first ReadAt: %v", err)
+	}
+	second := make([]byte, 3)
+	if _, err := entry.ReadAt(second, 3); err != nil {
+		t.Fatalf("second ReadAt: %v", err)
+	}
+	if got, want := string(first)+string(second), string(payload[:6]); got != want {
+		t.Fatalf("ReadAt(0)+ReadAt(3) = %q, want %q", got, want)
+	}
+}
+
+// TestEntryReadAtClampsTruncatedStream simulates a directory entry whose
+// declared size outruns what its sector chain actually holds (a malformed or
+// truncated file) and checks ReadAt reports io.EOF instead of panicking on
+// an out-of-range slice.
+func TestEntryReadAtClampsTruncatedStream(t *testing.T) {
+	payload := []byte("hello cfb!") //10 bytes, one short sector
+	data := buildSynthCFB(t, []testStream{{name: "Small", payload: payload, claimedSize: 100}})
+
+	cfile, err := NewCompoundFileFromReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("NewCompoundFileFromReader: %v", err)
+	}
+	entry := findEntry(t, cfile, "Small")
+
+	buf := make([]byte, 10)
+	n, err := entry.ReadAt(buf, 80)
+	if err != io.EOF {
+		t.Fatalf("ReadAt at truncated offset: err = %v, want io.EOF", err)
+	}
+	if n != 0 {
+		t.Fatalf("ReadAt at truncated offset: n = %d, want 0", n)
+	}
+}